@@ -16,30 +16,111 @@ import (
 // from it.
 const peekBufferSize = 4096
 
+// maxPreambleEpilogueBytes caps how much preamble/epilogue text we buffer,
+// so a message that never reaches (or never had) a real boundary can't be
+// used to exhaust memory.
+const maxPreambleEpilogueBytes = 64 * 1024
+
 var errNoBoundaryTerminator = stderrors.New("expected boundary not present")
 
 type boundaryReader struct {
-	finished         bool          // No parts remain when finished
-	partsRead        int           // Number of parts read thus far
-	r                *bufio.Reader // Source reader
-	nlPrefix         []byte        // NL + MIME boundary prefix
-	prefix           []byte        // MIME boundary prefix
-	final            []byte        // Final boundary prefix
-	buffer           *bytes.Buffer // Content waiting to be read
-	crBoundaryPrefix bool          // Flag for CR in CRLF + MIME boundary
-	unbounded        bool          // Flag to throw errNoBoundaryTerminator
+	finished   bool          // No parts remain when finished
+	partsRead  int           // Number of parts read thus far
+	r          *bufio.Reader // Source reader
+	nlPrefix   []byte        // NL + MIME boundary prefix
+	prefix     []byte        // MIME boundary prefix
+	final      []byte        // Final boundary prefix
+	total      int64         // Content bytes read for the current part
+	err        error         // Sticky error once the part is known to be finished
+	readErr    error         // Sticky error from the most recent underlying Peek
+	unbounded  bool          // Flag to throw errNoBoundaryTerminator
+	limits     ParserLimits  // Resource limits to enforce while reading
+	totalBytes int64         // Content bytes read across all parts
+	preamble   []byte        // Text seen before the first delimiter, capped at maxPreambleEpilogueBytes
+	epilogue   []byte        // Text seen after the terminator, capped at maxPreambleEpilogueBytes
+	lineEnding LineEnding    // Configured mode; LineEndingAuto sniffs and locks below
+	sniffed    LineEnding    // Locked mode once sniffed, LineEndingAuto until then
 }
 
 // newBoundaryReader returns an initialized boundaryReader
 func newBoundaryReader(reader *bufio.Reader, boundary string) *boundaryReader {
+	return newLimitedBoundaryReader(reader, boundary, defaultParserLimits(), LineEndingAuto)
+}
+
+// newLimitedBoundaryReader returns an initialized boundaryReader that
+// enforces the given ParserLimits and LineEnding mode.
+func newLimitedBoundaryReader(reader *bufio.Reader, boundary string, limits ParserLimits, lineEnding LineEnding) *boundaryReader {
 	fullBoundary := []byte("\n--" + boundary + "--")
 	return &boundaryReader{
-		r:        reader,
-		nlPrefix: fullBoundary[:len(fullBoundary)-2],
-		prefix:   fullBoundary[1 : len(fullBoundary)-2],
-		final:    fullBoundary[1:],
-		buffer:   new(bytes.Buffer),
+		r:          reader,
+		nlPrefix:   fullBoundary[:len(fullBoundary)-2],
+		prefix:     fullBoundary[1 : len(fullBoundary)-2],
+		final:      fullBoundary[1:],
+		limits:     limits,
+		lineEnding: lineEnding,
+	}
+}
+
+// effectiveLineEnding returns the LineEnding mode to use for boundary
+// matching: the configured mode if one was forced, otherwise the mode
+// sniffed from the first boundary line, defaulting to CRLF until enough of
+// the message has been seen to sniff it.
+func (b *boundaryReader) effectiveLineEnding() LineEnding {
+	if b.lineEnding != LineEndingAuto {
+		return b.lineEnding
 	}
+	if b.sniffed != LineEndingAuto {
+		return b.sniffed
+	}
+	return LineEndingCRLF
+}
+
+// sniffLineEnding peeks ahead for the first line that starts with the
+// boundary prefix immediately followed by "\r\n", "\r" or "\n" (a delimiter
+// line) and locks b.sniffed to that line ending, so the rest
+// of the multipart is matched consistently. It is a no-op once a mode is
+// configured or already sniffed.
+func (b *boundaryReader) sniffLineEnding() {
+	if b.lineEnding != LineEndingAuto || b.sniffed != LineEndingAuto {
+		return
+	}
+	peek, _ := b.r.Peek(peekBufferSize)
+	pos := 0
+	for {
+		idx := bytes.Index(peek[pos:], b.prefix)
+		if idx == -1 {
+			return
+		}
+		idx += pos
+
+		// Require the match to sit at the start of a line, so the literal
+		// boundary string appearing as plain preamble/body text can't be
+		// mistaken for an actual delimiter.
+		if idx > 0 && peek[idx-1] != '\n' && peek[idx-1] != '\r' {
+			pos = idx + 1
+			continue
+		}
+
+		after := peek[idx+len(b.prefix):]
+		switch {
+		case bytes.HasPrefix(after, []byte("\r\n")):
+			b.sniffed = LineEndingCRLF
+		case len(after) > 0 && after[0] == '\r':
+			b.sniffed = LineEndingCR
+		case len(after) > 0 && after[0] == '\n':
+			b.sniffed = LineEndingLF
+		default:
+			pos = idx + 1
+			continue
+		}
+		return
+	}
+}
+
+// LineEnding returns the line-ending convention locked in for this
+// boundary's parts.
+func (b *boundaryReader) LineEnding() LineEnding {
+	return b.effectiveLineEnding()
 }
 
 // Read returns a buffer containing the content up until boundary
@@ -69,101 +150,177 @@ func newBoundaryReader(reader *bufio.Reader, boundary string) *boundaryReader {
 //     considering the error err. Doing so correctly handles I/O errors
 //     that happen after reading some bytes and also both of the allowed
 //     EOF behaviors.
+//
+// This reads directly off the underlying bufio.Reader: each call peeks
+// ahead for the earliest point at which a boundary line could begin,
+// copies everything before it straight into dest, and leaves anything
+// ambiguous buffered for the next call to disambiguate once more data (or
+// EOF) is available.
 func (b *boundaryReader) Read(dest []byte) (n int, err error) {
-	if b.buffer.Len() >= len(dest) {
-		// This read request can be satisfied entirely by the buffer.
-		return b.buffer.Read(dest)
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(dest) == 0 {
+		return 0, nil
+	}
+	if b.readErr != nil && b.readErr != io.EOF {
+		// A prior Peek already failed with a hard I/O error; replay it
+		// instead of hitting the underlying reader again.
+		b.err = errors.WithStack(b.readErr)
+		return 0, b.err
+	}
+	if (b.limits.MaxPartBytes > 0 && b.total >= b.limits.MaxPartBytes) ||
+		(b.limits.MaxTotalBytes > 0 && b.totalBytes >= b.limits.MaxTotalBytes) {
+		// Content that exactly fills a limit has not exceeded it: if the
+		// boundary comes next, end the part cleanly instead.
+		if peek, peekErr := b.r.Peek(peekBufferSize); peekErr == nil || peekErr == io.EOF {
+			if cut, confirmed := b.scanBoundary(peek, peekErr == io.EOF); cut == 0 && confirmed {
+				b.err = io.EOF
+				return 0, io.EOF
+			}
+		}
+	}
+	if b.limits.MaxPartBytes > 0 && b.total >= b.limits.MaxPartBytes {
+		b.err = errors.WithStack(ErrPartTooLarge)
+		return 0, b.err
+	}
+	if b.limits.MaxTotalBytes > 0 && b.totalBytes >= b.limits.MaxTotalBytes {
+		b.err = errors.WithStack(ErrMessageTooLarge)
+		return 0, b.err
 	}
 
-	for i := 0; i < cap(dest); i++ {
-		c, err := b.r.Peek(1)
-		if err != nil && err != io.EOF {
+	peek, peekErr := b.r.Peek(peekBufferSize)
+	if peekErr != nil && peekErr != io.EOF {
+		b.readErr = peekErr
+		return 0, errors.WithStack(peekErr)
+	}
+	b.readErr = peekErr
+
+	if len(peek) == 0 && peekErr == io.EOF {
+		// Drained the underlying reader without ever finding a boundary.
+		b.unbounded = true
+		b.err = io.EOF
+		return 0, io.EOF
+	}
+
+	cut, confirmed := b.scanBoundary(peek, peekErr == io.EOF)
+	if cut > len(dest) {
+		// Only part of the safe prefix fits; boundary confirmation (if any)
+		// waits until the rest has been delivered to the caller.
+		cut = len(dest)
+		confirmed = false
+	}
+	if b.limits.MaxPartBytes > 0 {
+		// Never copy past the per-part cap, even when the whole rest of
+		// the part sits inside this one peek window; the next call will
+		// see b.total at the cap and, unless the boundary follows, raise
+		// ErrPartTooLarge.
+		if remaining := b.limits.MaxPartBytes - b.total; int64(cut) > remaining {
+			cut = int(remaining)
+			confirmed = false
+		}
+	}
+	if b.limits.MaxTotalBytes > 0 {
+		if remaining := b.limits.MaxTotalBytes - b.totalBytes; int64(cut) > remaining {
+			cut = int(remaining)
+			confirmed = false
+		}
+	}
+
+	if cut > 0 {
+		n = copy(dest, peek[:cut])
+		if _, err := b.r.Discard(n); err != nil {
 			return 0, errors.WithStack(err)
 		}
-		// Ensure that we can switch on the first byte of 'c' without panic.
-		if len(c) > 0 {
-			switch c[0] {
-			// Check for line feed as potential LF boundary prefix.
-			case '\n':
-				peek, err := b.r.Peek(len(b.nlPrefix) + 2)
-				switch err {
-				case nil:
-					// Check the whitespace at the head of the peek to avoid checking for a boundary early.
-					if bytes.HasPrefix(peek, []byte("\n\n")) ||
-						bytes.HasPrefix(peek, []byte("\n\r")) {
-						break
-					}
-					// Check the peek buffer for a boundary delimiter or terminator.
-					if b.isDelimiter(peek[1:]) || b.isTerminator(peek[1:]) {
-						// Check if we stored a carriage return.
-						if b.crBoundaryPrefix {
-							b.crBoundaryPrefix = false
-							// Let us now unread that back onto the io.Reader, since
-							// we have found what we are looking for and this byte
-							// belongs to the bounded block we are reading.
-							err = b.r.UnreadByte()
-							switch err {
-							case nil:
-								// Carry on.
-							case bufio.ErrInvalidUnreadByte:
-								// Carriage return boundary prefix bit already unread.
-							default:
-								return 0, errors.WithStack(err)
-							}
-						}
-						// We have found our boundary terminator, lets write out the final bytes
-						// and return io.EOF to indicate that this section read is complete.
-						n, err = b.buffer.Read(dest)
-						switch err {
-						case nil, io.EOF:
-							return n, io.EOF
-						default:
-							return 0, errors.WithStack(err)
-						}
-					}
-				case io.EOF:
-					// We have reached the end without finding a boundary,
-					// so we flag the boundary reader to add an error to
-					// the errors slice and write what we have to the buffer.
-					b.unbounded = true
-				default:
-					continue
-				}
-				// Checked '\n' was not prefix to a boundary.
-				if b.crBoundaryPrefix {
-					b.crBoundaryPrefix = false
-					// Stored '\r' should be written to the buffer now.
-					err = b.buffer.WriteByte('\r')
-					if err != nil {
-						return 0, errors.WithStack(err)
-					}
+		b.total += int64(n)
+		b.totalBytes += int64(n)
+	}
+
+	if confirmed {
+		b.err = io.EOF
+		if n > 0 {
+			return n, nil
+		}
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// scanBoundary scans peek for the earliest index that could be the start of
+// a boundary line ("\r\n--boundary" or "\n--boundary", or "\r--boundary" in
+// LineEndingCR mode). It returns the
+// number of leading bytes that are safe to treat as part content, and
+// whether a boundary delimiter or terminator is confirmed to start
+// immediately after them. When a '\r' or '\n' candidate is found too close
+// to the end of peek to rule in or out (and atEOF is false, so more data
+// may yet arrive), it stops just short of that candidate so the caller can
+// leave it buffered for the next call.
+func (b *boundaryReader) scanBoundary(peek []byte, atEOF bool) (cut int, confirmed bool) {
+	mode := b.effectiveLineEnding()
+	pos := 0
+	for {
+		idx := bytes.IndexAny(peek[pos:], "\r\n")
+		if idx == -1 {
+			return len(peek), false
+		}
+		idx += pos
+
+		if peek[idx] == '\r' {
+			if mode == LineEndingLF {
+				// Bare \r is ordinary content when the multipart uses LF.
+				pos = idx + 1
+				continue
+			}
+			if mode == LineEndingCR {
+				// In CR mode the \r itself is the line terminator.
+				if idx+1+len(b.nlPrefix)+1 > len(peek) && !atEOF {
+					return idx, false
 				}
-			// Check for carriage return as potential CRLF boundary prefix.
-			case '\r':
-				_, err := b.r.ReadByte()
-				if err != nil {
-					return 0, errors.WithStack(err)
+				if b.isDelimiter(peek[idx+1:]) || b.isTerminator(peek[idx+1:]) {
+					return idx, true
 				}
-				// Flag the boundary reader to indicate that we
-				// have stored a '\r' as a potential CRLF prefix.
-				b.crBoundaryPrefix = true
+				pos = idx + 1
 				continue
 			}
+			switch {
+			case idx+1 < len(peek) && peek[idx+1] == '\n':
+				// \r\n: test the bytes following it for a boundary line.
+				if idx+2+len(b.nlPrefix)+1 > len(peek) && !atEOF {
+					return idx, false
+				}
+				if b.isDelimiter(peek[idx+2:]) || b.isTerminator(peek[idx+2:]) {
+					return idx, true
+				}
+				pos = idx + 2
+			case idx+1 < len(peek):
+				// Lone \r, never the start of a boundary line by itself.
+				pos = idx + 1
+			default:
+				// \r is the very last peeked byte; need the next byte to
+				// know whether it's paired with a \n.
+				if !atEOF {
+					return idx, false
+				}
+				return len(peek), false
+			}
+			continue
 		}
 
-		_, err = io.CopyN(b.buffer, b.r, 1)
-		if err != nil {
-			// EOF is not fatal, it just means that we have drained the reader.
-			if errors.Cause(err) == io.EOF {
-				break
-			}
-			return 0, err
+		// peek[idx] == '\n'
+		if mode == LineEndingCR {
+			// Bare \n is ordinary content when the multipart uses CR.
+			pos = idx + 1
+			continue
+		}
+		if idx+1+len(b.nlPrefix)+1 > len(peek) && !atEOF {
+			return idx, false
 		}
+		if b.isDelimiter(peek[idx+1:]) || b.isTerminator(peek[idx+1:]) {
+			return idx, true
+		}
+		pos = idx + 1
 	}
-
-	// Read the contents of the buffer into the destination slice.
-	n, err = b.buffer.Read(dest)
-	return n, err
 }
 
 // Next moves over the boundary to the next part, returns true if there is another part to be read.
@@ -175,22 +332,44 @@ func (b *boundaryReader) Next() (bool, error) {
 		// Exhaust the current part to prevent errors when moving to the next part.
 		_, _ = io.Copy(ioutil.Discard, b)
 	}
+	b.err = nil
+	b.total = 0
 	for {
-		line, err := b.r.ReadSlice('\n')
+		if b.partsRead == 0 {
+			// Re-sniff on every preamble line: a preamble longer than one
+			// peek window would otherwise strand us on the CRLF default
+			// before the real boundary ever comes into view.
+			b.sniffLineEnding()
+		}
+		lineDelim := byte('\n')
+		if b.effectiveLineEnding() == LineEndingCR {
+			lineDelim = '\r'
+		}
+		line, err := b.r.ReadSlice(lineDelim)
 		if err != nil && err != io.EOF {
 			return false, errors.WithStack(err)
 		}
 		if len(line) > 0 && (line[0] == '\r' || line[0] == '\n') {
 			// Blank line
+			if b.partsRead == 0 {
+				b.appendPreamble(line)
+			}
 			continue
 		}
 		if b.isTerminator(line) {
 			b.finished = true
+			if epilogueErr := b.captureEpilogue(); epilogueErr != nil {
+				return false, errors.WithStack(epilogueErr)
+			}
 			return false, nil
 		}
 		if err != io.EOF && b.isDelimiter(line) {
 			// Start of a new part.
 			b.partsRead++
+			if b.limits.MaxParts > 0 && b.partsRead > b.limits.MaxParts {
+				b.finished = true
+				return false, errors.WithStack(ErrTooManyParts)
+			}
 			return true, nil
 		}
 		if err == io.EOF {
@@ -200,6 +379,7 @@ func (b *boundaryReader) Next() (bool, error) {
 		if b.partsRead == 0 {
 			// The first part didn't find the starting delimiter, burn off any preamble in front of
 			// the boundary.
+			b.appendPreamble(line)
 			continue
 		}
 		b.finished = true
@@ -207,26 +387,56 @@ func (b *boundaryReader) Next() (bool, error) {
 	}
 }
 
-// isDelimiter returns true for --BOUNDARY\r\n but not --BOUNDARY--
-func (b *boundaryReader) isDelimiter(buf []byte) bool {
-	idx := bytes.Index(buf, b.prefix)
-	if idx == -1 {
-		return false
-	}
-
-	// Fast forward to the end of the boundary prefix.
-	buf = buf[idx+len(b.prefix):]
-	if len(buf) > 0 {
-		if unicode.IsSpace(rune(buf[0])) {
-			return true
+// appendPreamble buffers line into the preamble, subject to
+// maxPreambleEpilogueBytes, to avoid an attacker being able to exhaust
+// memory with a boundary that's never reached.
+func (b *boundaryReader) appendPreamble(line []byte) {
+	if room := maxPreambleEpilogueBytes - len(b.preamble); room > 0 {
+		if room > len(line) {
+			room = len(line)
 		}
+		b.preamble = append(b.preamble, line[:room]...)
 	}
+}
+
+// captureEpilogue drains any text following the boundary terminator into
+// b.epilogue, up to maxPreambleEpilogueBytes. Whatever was read is kept even
+// if the underlying reader then fails with a hard I/O error; that error is
+// returned so the caller can propagate it instead of reporting a clean EOF.
+func (b *boundaryReader) captureEpilogue() error {
+	epilogue, err := ioutil.ReadAll(io.LimitReader(b.r, maxPreambleEpilogueBytes))
+	b.epilogue = epilogue
+	return err
+}
+
+// Preamble returns any text RFC 2046 allows before the first boundary
+// delimiter, such as a human-readable "this is a multi-part message" note.
+func (b *boundaryReader) Preamble() []byte {
+	return b.preamble
+}
 
-	return false
+// Epilogue returns any text RFC 2046 allows after the final boundary
+// terminator, such as a digital-signature notice.
+func (b *boundaryReader) Epilogue() []byte {
+	return b.epilogue
+}
+
+// isDelimiter returns true if buf starts with --BOUNDARY followed by
+// whitespace, i.e. --BOUNDARY\r\n but not --BOUNDARY--. The match must
+// anchor at the start of buf: scanBoundary only calls this with the bytes
+// immediately following a line break, so a later occurrence of the
+// boundary prefix further down buf must not count as this line being a
+// delimiter.
+func (b *boundaryReader) isDelimiter(buf []byte) bool {
+	if !bytes.HasPrefix(buf, b.prefix) {
+		return false
+	}
+	rest := buf[len(b.prefix):]
+	return len(rest) > 0 && unicode.IsSpace(rune(rest[0]))
 }
 
-// isTerminator returns true for --BOUNDARY--
+// isTerminator returns true if buf starts with --BOUNDARY--. See
+// isDelimiter for why the match must anchor at the start of buf.
 func (b *boundaryReader) isTerminator(buf []byte) bool {
-	idx := bytes.Index(buf, b.final)
-	return idx != -1
+	return bytes.HasPrefix(buf, b.final)
 }
@@ -0,0 +1,142 @@
+package enmime
+
+import (
+	"bufio"
+	stderrors "errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// errAfterReader returns body once, followed by err on every subsequent
+// Read, simulating a hard I/O failure partway through a stream.
+type errAfterReader struct {
+	body []byte
+	err  error
+	read bool
+}
+
+func (r *errAfterReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, r.err
+	}
+	r.read = true
+	return copy(p, r.body), nil
+}
+
+func TestBoundaryReaderMaxPartBytesWithinSinglePeek(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\r\n" + strings.Repeat("x", 100) + "\r\n--" + boundary + "--\r\n"
+
+	br := newLimitedBoundaryReader(bufio.NewReader(strings.NewReader(msg)), boundary, ParserLimits{MaxPartBytes: 10}, LineEndingAuto)
+
+	more, err := br.Next()
+	if err != nil || !more {
+		t.Fatalf("Next() = %v, %v; want true, nil", more, err)
+	}
+
+	content, err := ioutil.ReadAll(br)
+	if errors.Cause(err) != ErrPartTooLarge {
+		t.Fatalf("ReadAll() err = %v; want ErrPartTooLarge", err)
+	}
+	if len(content) > 10 {
+		t.Fatalf("ReadAll() returned %d bytes; want at most 10", len(content))
+	}
+}
+
+func TestBoundaryReaderMultiPeekRead(t *testing.T) {
+	const boundary = "sep"
+	want := strings.Repeat("0123456789", 1000) // 10000 bytes, well past peekBufferSize
+	msg := "--" + boundary + "\r\n" + want + "\r\n--" + boundary + "--\r\n"
+
+	br := newBoundaryReader(bufio.NewReader(strings.NewReader(msg)), boundary)
+
+	more, err := br.Next()
+	if err != nil || !more {
+		t.Fatalf("Next() = %v, %v; want true, nil", more, err)
+	}
+
+	// Read in small chunks so the part spans many Read calls, not just many
+	// underlying Peek calls.
+	var got []byte
+	buf := make([]byte, 16)
+	for {
+		n, err := br.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+
+	if string(got) != want {
+		t.Fatalf("content length = %d; want %d (content corrupted across multiple peeks)", len(got), len(want))
+	}
+}
+
+func TestBoundaryReaderBoundaryStraddlesPeekWindow(t *testing.T) {
+	const boundary = "sep"
+
+	// Place the terminating CRLF at a range of offsets around the edge of
+	// peekBufferSize so the boundary delimiter itself is sometimes only
+	// partially visible in the first Peek, forcing Read to defer
+	// confirmation to a subsequent call.
+	for offset := peekBufferSize - 8; offset <= peekBufferSize+8; offset++ {
+		want := strings.Repeat("x", offset)
+		msg := "--" + boundary + "\r\n" + want + "\r\n--" + boundary + "--\r\n"
+
+		br := newBoundaryReader(bufio.NewReader(strings.NewReader(msg)), boundary)
+
+		more, err := br.Next()
+		if err != nil || !more {
+			t.Fatalf("offset %d: Next() = %v, %v; want true, nil", offset, more, err)
+		}
+
+		got, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("offset %d: ReadAll() err = %v", offset, err)
+		}
+		if string(got) != want {
+			t.Fatalf("offset %d: content length = %d; want %d", offset, len(got), len(want))
+		}
+
+		more, err = br.Next()
+		if more || err != nil {
+			t.Fatalf("offset %d: Next() = %v, %v; want false, nil", offset, more, err)
+		}
+	}
+}
+
+func TestBoundaryReaderEmbeddedBoundaryLikeTextMidLine(t *testing.T) {
+	const boundary = "sep"
+	want := "look, a fake boundary: --" + boundary + " right here"
+	msg := "--" + boundary + "\r\n" + want + "\r\n--" + boundary + "--\r\n"
+
+	br := newBoundaryReader(bufio.NewReader(strings.NewReader(msg)), boundary)
+
+	more, err := br.Next()
+	if err != nil || !more {
+		t.Fatalf("Next() = %v, %v; want true, nil", more, err)
+	}
+
+	got, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ReadAll() err = %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("content = %q; want %q (embedded boundary-like text misread as a delimiter)", got, want)
+	}
+}
+
+func TestCaptureEpilogueKeepsPartialBytesOnHardError(t *testing.T) {
+	wantErr := stderrors.New("boom")
+	b := &boundaryReader{r: bufio.NewReader(&errAfterReader{body: []byte("partial epilogue"), err: wantErr})}
+
+	if err := b.captureEpilogue(); err != wantErr {
+		t.Fatalf("captureEpilogue() err = %v; want %v", err, wantErr)
+	}
+	if got := string(b.Epilogue()); got != "partial epilogue" {
+		t.Errorf("Epilogue() = %q; want %q", got, "partial epilogue")
+	}
+}
@@ -0,0 +1,38 @@
+package enmime
+
+// Error describes a problem that occurred while parsing a message. Errors
+// that prevented a part of the message from being decoded are marked
+// Severe; informational issues (e.g. a malformed but recoverable header)
+// are not.
+type Error struct {
+	Name   string // The name of the Error type
+	Detail string // Additional detail about the error
+	Severe bool   // True if the error should be considered fatal by callers
+}
+
+// Envelope is a simplified wrapper for decoded mail messages.
+type Envelope struct {
+	// Errors accumulates problems encountered while parsing, including
+	// ParserLimits breaches that prevented the full message from being
+	// decoded.
+	Errors []*Error
+
+	// Parts holds each MIME part decoded from the message, in order. If a
+	// ParserLimits breach cuts parsing short, Parts holds whatever was
+	// successfully decoded before the breach, and the breach itself is
+	// recorded in Errors. The part during which the breach occurred is
+	// included; after a byte limit breach its Content stops at the limit.
+	Parts []*Part
+
+	// Preamble is any text that appeared before the first MIME boundary
+	// delimiter of a multipart message, as allowed by RFC 2046.
+	Preamble []byte
+	// Epilogue is any text that appeared after the final MIME boundary
+	// terminator of a multipart message, as allowed by RFC 2046.
+	Epilogue []byte
+}
+
+// addError records a new Error on the Envelope.
+func (e *Envelope) addError(name, detail string, severe bool) {
+	e.Errors = append(e.Errors, &Error{Name: name, Detail: detail, Severe: severe})
+}
@@ -0,0 +1,64 @@
+package enmime
+
+import stderrors "errors"
+
+// Default limits mirror the bounds Go's stdlib mime/multipart package
+// applies to guard against maliciously crafted multipart bodies.
+const (
+	defaultMaxParts          = 1000
+	defaultMaxHeadersPerPart = 10000
+	defaultMaxTotalHeaders   = 10000
+)
+
+// ParserLimits bounds the resources a Parser will spend decoding a
+// multipart message, so that a hostile or malformed input cannot exhaust
+// memory or CPU by declaring an unbounded number or size of parts or
+// headers.
+//
+// A zero value for any field means that dimension is unbounded.
+type ParserLimits struct {
+	// MaxParts is the maximum number of MIME parts a single multipart body
+	// may contain.
+	MaxParts int
+	// MaxHeadersPerPart is the maximum number of header lines allowed on a
+	// single part, counted up to (but not including) the blank line that
+	// separates a part's headers from its body.
+	MaxHeadersPerPart int
+	// MaxTotalHeaders is the maximum number of header lines allowed across
+	// every part of a message.
+	MaxTotalHeaders int
+	// MaxPartBytes is the maximum number of content bytes read for a
+	// single part.
+	MaxPartBytes int64
+	// MaxTotalBytes is the maximum number of content bytes read across
+	// every part of a message.
+	MaxTotalBytes int64
+}
+
+// defaultParserLimits returns the limits enmime applies unless a caller
+// overrides them with Parser.WithLimits, matching the bounds Go's stdlib
+// mime/multipart enforces by default.
+func defaultParserLimits() ParserLimits {
+	return ParserLimits{
+		MaxParts:          defaultMaxParts,
+		MaxHeadersPerPart: defaultMaxHeadersPerPart,
+		MaxTotalHeaders:   defaultMaxTotalHeaders,
+	}
+}
+
+// ErrTooManyParts is returned when a multipart body contains more parts
+// than ParserLimits.MaxParts allows.
+var ErrTooManyParts = stderrors.New("enmime: too many parts in multipart message")
+
+// ErrPartTooLarge is returned when a single part's content exceeds
+// ParserLimits.MaxPartBytes.
+var ErrPartTooLarge = stderrors.New("enmime: part exceeds maximum allowed size")
+
+// ErrTooManyHeaders is returned when a part's headers exceed
+// ParserLimits.MaxHeadersPerPart, or a message's headers exceed
+// ParserLimits.MaxTotalHeaders.
+var ErrTooManyHeaders = stderrors.New("enmime: too many headers")
+
+// ErrMessageTooLarge is returned when a message's total part content
+// exceeds ParserLimits.MaxTotalBytes.
+var ErrMessageTooLarge = stderrors.New("enmime: message exceeds maximum allowed size")
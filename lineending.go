@@ -0,0 +1,18 @@
+package enmime
+
+// LineEnding identifies the line-ending convention used to separate a
+// multipart message's boundary lines from the content around them.
+type LineEnding int
+
+const (
+	// LineEndingAuto sniffs the terminator of the first boundary line
+	// encountered and locks that mode for the remainder of the multipart.
+	LineEndingAuto LineEnding = iota
+	// LineEndingCRLF is the RFC 2046 canonical "\r\n" convention.
+	LineEndingCRLF
+	// LineEndingLF is the bare "\n" convention used by most Unix MTAs.
+	LineEndingLF
+	// LineEndingCR is the bare "\r" convention used by classic Mac OS mail
+	// tools.
+	LineEndingCR
+)
@@ -0,0 +1,136 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	stderrors "errors"
+	"io"
+	"io/ioutil"
+)
+
+// Parser parses MIME email messages into Envelope structs, applying
+// ParserLimits to guard against malicious or malformed input along the way.
+type Parser struct {
+	limits     ParserLimits
+	lineEnding LineEnding
+}
+
+// NewParser creates a new Parser configured with enmime's default
+// ParserLimits and LineEndingAuto.
+func NewParser() *Parser {
+	return &Parser{limits: defaultParserLimits(), lineEnding: LineEndingAuto}
+}
+
+// WithLimits returns a copy of p configured to enforce the supplied
+// ParserLimits instead of the defaults.
+func (p *Parser) WithLimits(limits ParserLimits) *Parser {
+	p2 := *p
+	p2.limits = limits
+	return &p2
+}
+
+// WithLineEnding returns a copy of p configured to assume the given
+// LineEnding convention for multipart boundaries instead of sniffing it
+// from the message.
+func (p *Parser) WithLineEnding(mode LineEnding) *Parser {
+	p2 := *p
+	p2.lineEnding = mode
+	return &p2
+}
+
+// ReadParts decodes a multipart body read from r, delimited by boundary,
+// into an Envelope. A ParserLimits breach does not fail the call outright;
+// it is recorded as a Severe Error on the returned Envelope alongside
+// whatever Parts were successfully decoded before the breach, plus the part
+// during which it occurred.
+func (p *Parser) ReadParts(r io.Reader, boundary string) *Envelope {
+	br := newLimitedBoundaryReader(bufio.NewReader(r), boundary, p.limits, p.lineEnding)
+	env := &Envelope{}
+	var totalHeaders int
+
+	for {
+		more, err := br.Next()
+		if err != nil {
+			if err != io.EOF {
+				env.addError(limitErrorName(err), err.Error(), true)
+			}
+			break
+		}
+		if !more {
+			break
+		}
+
+		content, readErr := ioutil.ReadAll(br)
+		env.Parts = append(env.Parts, &Part{Content: content, LineEnding: br.LineEnding()})
+
+		headers := countPartHeaders(content, br.LineEnding())
+		totalHeaders += headers
+		if p.limits.MaxHeadersPerPart > 0 && headers > p.limits.MaxHeadersPerPart {
+			env.addError(limitErrorName(ErrTooManyHeaders), ErrTooManyHeaders.Error(), true)
+			break
+		}
+		if p.limits.MaxTotalHeaders > 0 && totalHeaders > p.limits.MaxTotalHeaders {
+			env.addError(limitErrorName(ErrTooManyHeaders), ErrTooManyHeaders.Error(), true)
+			break
+		}
+
+		if readErr != nil && readErr != io.EOF {
+			env.addError(limitErrorName(readErr), readErr.Error(), true)
+			break
+		}
+	}
+
+	env.Preamble = br.Preamble()
+	env.Epilogue = br.Epilogue()
+
+	return env
+}
+
+// countPartHeaders returns the number of header lines in content, counted
+// up to (but not including) the first blank line, using newline to split
+// lines in the same line-ending convention the part's boundary used. It
+// does not unfold continuation lines; that distinction doesn't matter for
+// bounding resource use, which is all this count is used for.
+func countPartHeaders(content []byte, lineEnding LineEnding) int {
+	newline := []byte("\r\n")
+	switch lineEnding {
+	case LineEndingLF:
+		newline = []byte("\n")
+	case LineEndingCR:
+		newline = []byte("\r")
+	}
+
+	count := 0
+	rest := content
+	for len(rest) > 0 {
+		var line []byte
+		if idx := bytes.Index(rest, newline); idx != -1 {
+			line, rest = rest[:idx], rest[idx+len(newline):]
+		} else {
+			line, rest = rest, nil
+		}
+		if len(line) == 0 {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// limitErrorName returns the Error.Name to record for err, recognizing the
+// ParserLimits sentinel errors regardless of wrapping; it falls back to a
+// generic name for any other hard read error.
+func limitErrorName(err error) string {
+	switch {
+	case stderrors.Is(err, ErrTooManyParts):
+		return "TooManyParts"
+	case stderrors.Is(err, ErrPartTooLarge):
+		return "PartTooLarge"
+	case stderrors.Is(err, ErrMessageTooLarge):
+		return "MessageTooLarge"
+	case stderrors.Is(err, ErrTooManyHeaders):
+		return "TooManyHeaders"
+	default:
+		return "Read"
+	}
+}
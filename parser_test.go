@@ -0,0 +1,98 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParserReadPartsDecodesContent(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\r\nfirst\r\n--" + boundary + "\r\nsecond\r\n--" + boundary + "--\r\n"
+
+	env := NewParser().ReadParts(strings.NewReader(msg), boundary)
+
+	if len(env.Errors) != 0 {
+		t.Fatalf("Errors = %v; want none", env.Errors)
+	}
+	if len(env.Parts) != 2 {
+		t.Fatalf("len(Parts) = %d; want 2", len(env.Parts))
+	}
+	if got := string(env.Parts[0].Content); got != "first" {
+		t.Errorf("Parts[0].Content = %q; want %q", got, "first")
+	}
+	if got := string(env.Parts[1].Content); got != "second" {
+		t.Errorf("Parts[1].Content = %q; want %q", got, "second")
+	}
+}
+
+func TestParserReadPartsExposesPreambleAndEpilogue(t *testing.T) {
+	const boundary = "sep"
+	msg := "a preamble note\r\n--" + boundary + "\r\nbody\r\n--" + boundary + "--\r\nan epilogue note"
+
+	env := NewParser().ReadParts(strings.NewReader(msg), boundary)
+
+	if got := string(env.Preamble); got != "a preamble note\r\n" {
+		t.Errorf("Preamble = %q; want %q", got, "a preamble note\r\n")
+	}
+	if got := string(env.Epilogue); got != "an epilogue note" {
+		t.Errorf("Epilogue = %q; want %q", got, "an epilogue note")
+	}
+}
+
+func TestParserReadPartsSetsPartLineEnding(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\nfirst\n--" + boundary + "\nsecond\n--" + boundary + "--\n"
+
+	env := NewParser().ReadParts(strings.NewReader(msg), boundary)
+
+	if len(env.Parts) != 2 {
+		t.Fatalf("len(Parts) = %d; want 2", len(env.Parts))
+	}
+	for i, part := range env.Parts {
+		if part.LineEnding != LineEndingLF {
+			t.Errorf("Parts[%d].LineEnding = %v; want LineEndingLF", i, part.LineEnding)
+		}
+	}
+}
+
+func TestParserReadPartsSurfacesTooManyHeadersPerPart(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\r\nH1: a\r\nH2: b\r\nH3: c\r\n\r\nbody\r\n--" + boundary + "--\r\n"
+
+	p := NewParser().WithLimits(ParserLimits{MaxHeadersPerPart: 2})
+	env := p.ReadParts(strings.NewReader(msg), boundary)
+
+	if len(env.Errors) != 1 || env.Errors[0].Name != "TooManyHeaders" || !env.Errors[0].Severe {
+		t.Fatalf("Errors = %v; want one Severe TooManyHeaders error", env.Errors)
+	}
+}
+
+func TestParserReadPartsSurfacesTooManyTotalHeaders(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\r\nH1: a\r\n\r\nfirst\r\n--" + boundary + "\r\nH2: b\r\n\r\nsecond\r\n--" + boundary + "--\r\n"
+
+	p := NewParser().WithLimits(ParserLimits{MaxTotalHeaders: 1})
+	env := p.ReadParts(strings.NewReader(msg), boundary)
+
+	if len(env.Errors) != 1 || env.Errors[0].Name != "TooManyHeaders" || !env.Errors[0].Severe {
+		t.Fatalf("Errors = %v; want one Severe TooManyHeaders error", env.Errors)
+	}
+	if len(env.Parts) != 2 {
+		t.Fatalf("len(Parts) = %d; want 2 (both decoded before the breach is noticed)", len(env.Parts))
+	}
+}
+
+func TestParserReadPartsSurfacesTooManyParts(t *testing.T) {
+	const boundary = "sep"
+	msg := "--" + boundary + "\r\na\r\n--" + boundary + "\r\nb\r\n--" + boundary + "\r\nc\r\n--" + boundary + "--\r\n"
+
+	p := NewParser().WithLimits(ParserLimits{MaxParts: 2})
+	env := p.ReadParts(strings.NewReader(msg), boundary)
+
+	if len(env.Errors) != 1 || env.Errors[0].Name != "TooManyParts" || !env.Errors[0].Severe {
+		t.Fatalf("Errors = %v; want one Severe TooManyParts error", env.Errors)
+	}
+	if len(env.Parts) != 2 {
+		t.Fatalf("len(Parts) = %d; want 2 (decoded before the breach)", len(env.Parts))
+	}
+}
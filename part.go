@@ -0,0 +1,13 @@
+package enmime
+
+// Part represents a single part of a multipart message.
+type Part struct {
+	// Content holds the part's raw bytes, up to (but not including) the
+	// boundary that ends it.
+	Content []byte
+
+	// LineEnding is the line-ending convention observed (or forced via
+	// Parser.WithLineEnding) for the boundary enclosing this part, so that
+	// callers re-encoding the message can match it.
+	LineEnding LineEnding
+}